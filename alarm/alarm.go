@@ -0,0 +1,623 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+
+// Package alarm holds the CloudWatch/EC2 logic shared by the CLI (the root main package)
+// and the Lambda handler (cmd/lambda), so both can create and delete the
+// same "awsec2-<instance>-<prefix>" alarms from a single implementation.
+package alarm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Prefix is the common prefix shared by every alarm this tool creates, used
+// by reconcile mode to tell its own alarms apart from unrelated ones.
+const Prefix = "awsec2-"
+
+// CWEnableAlarmAPI defines the interface for the CloudWatch functions this package calls:
+// PutMetricAlarm, EnableAlarmActions, DescribeAlarms, DeleteAlarms, and PutCompositeAlarm.
+// We use this interface to test the function using a mocked service.
+type CWEnableAlarmAPI interface {
+	PutMetricAlarm(ctx context.Context,
+		params *cloudwatch.PutMetricAlarmInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error)
+	EnableAlarmActions(ctx context.Context,
+		params *cloudwatch.EnableAlarmActionsInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.EnableAlarmActionsOutput, error)
+	DescribeAlarms(ctx context.Context,
+		params *cloudwatch.DescribeAlarmsInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error)
+	DeleteAlarms(ctx context.Context,
+		params *cloudwatch.DeleteAlarmsInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error)
+	PutCompositeAlarm(ctx context.Context,
+		params *cloudwatch.PutCompositeAlarmInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutCompositeAlarmOutput, error)
+}
+
+// Options configures the alarm(s) created for a single EC2 instance. The metric fields
+// (Namespace, MetricName, Statistic, Operator, EvaluationPeriods, DatapointsToAlarm, Unit) each
+// default to the original CPUUtilization-below-threshold behavior when left zero-valued; see
+// withDefaults.
+type Options struct {
+	AlarmNamePrefix   string  // Alarm name prefix
+	NameSuffix        string  // distinguishes multiple metric alarms sharing one AlarmNamePrefix, e.g. from a config file
+	SNSTopic          string  // Default_CloudWatch_Alarms_Topic
+	Action            string  // Terminate, Stop, Reboot
+	Threshold         float64 // 0-100
+	Period            int32   // 1, 5, 10, 30, or multiples of 60
+	Namespace         string  // CloudWatch namespace, e.g. AWS/EC2 (default: AWS/EC2)
+	MetricName        string  // CloudWatch metric name, e.g. CPUUtilization (default: CPUUtilization)
+	Statistic         string  // Average, Sum, SampleCount, Minimum, or Maximum (default: Average)
+	Operator          string  // comparison operator, e.g. LessThanThreshold (default: LessThanThreshold)
+	EvaluationPeriods int32   // number of periods to evaluate (default: 1)
+	DatapointsToAlarm int32   // number of datapoints within EvaluationPeriods that must breach (default: EvaluationPeriods)
+	Unit              string  // CloudWatch unit, e.g. Percent (optional)
+	DryRun            bool    // print the intended diff instead of calling PutMetricAlarm
+}
+
+// withDefaults fills in the zero-valued metric fields of opts with the tool's original
+// CPUUtilization-below-threshold behavior, so callers that only set AlarmNamePrefix/SNSTopic/
+// Action/Threshold/Period keep working unchanged.
+func withDefaults(opts Options) Options {
+	if opts.Namespace == "" {
+		opts.Namespace = "AWS/EC2"
+	}
+	if opts.MetricName == "" {
+		opts.MetricName = "CPUUtilization"
+	}
+	if opts.Statistic == "" {
+		opts.Statistic = string(types.StatisticAverage)
+	}
+	if opts.Operator == "" {
+		opts.Operator = string(types.ComparisonOperatorLessThanThreshold)
+	}
+	if opts.EvaluationPeriods == 0 {
+		opts.EvaluationPeriods = 1
+	}
+	if opts.DatapointsToAlarm == 0 {
+		opts.DatapointsToAlarm = opts.EvaluationPeriods
+	}
+	return opts
+}
+
+// ValidateStatistic returns the types.Statistic matching s, or an error if s is not one of the
+// CloudWatch SDK's known statistic enum values.
+func ValidateStatistic(s string) (types.Statistic, error) {
+	switch types.Statistic(s) {
+	case types.StatisticAverage, types.StatisticSum, types.StatisticSampleCount, types.StatisticMinimum, types.StatisticMaximum:
+		return types.Statistic(s), nil
+	default:
+		return "", fmt.Errorf("invalid statistic %q", s)
+	}
+}
+
+// ValidateOperator returns the types.ComparisonOperator matching op, or an error if op is not one
+// of the CloudWatch SDK's known comparison-operator enum values.
+func ValidateOperator(op string) (types.ComparisonOperator, error) {
+	switch types.ComparisonOperator(op) {
+	case types.ComparisonOperatorGreaterThanOrEqualToThreshold,
+		types.ComparisonOperatorGreaterThanThreshold,
+		types.ComparisonOperatorLessThanThreshold,
+		types.ComparisonOperatorLessThanOrEqualToThreshold,
+		types.ComparisonOperatorLessThanLowerOrGreaterThanUpperThreshold,
+		types.ComparisonOperatorLessThanLowerThreshold,
+		types.ComparisonOperatorGreaterThanUpperThreshold:
+		return types.ComparisonOperator(op), nil
+	default:
+		return "", fmt.Errorf("invalid comparison operator %q", op)
+	}
+}
+
+// AlarmName returns the name of the alarm this tool creates for instanceID under opts, in the
+// form "awsec2-<instance>-<prefix>", or "awsec2-<instance>-<prefix>-<suffix>" when opts.NameSuffix
+// is set to disambiguate multiple metric alarms declared for the same instance.
+func AlarmName(instanceID string, opts Options) string {
+	if opts.NameSuffix != "" {
+		return fmt.Sprintf(Prefix+"%s-%s-%s", instanceID, opts.AlarmNamePrefix, opts.NameSuffix)
+	}
+	return fmt.Sprintf(Prefix+"%s-%s", instanceID, opts.AlarmNamePrefix)
+}
+
+// CreateMetricAlarm creates a metric alarm
+// Inputs:
+//     c is the context of the method call, which includes the Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a PutMetricAlarmOutput object containing the result of the service call and nil
+//     Otherwise, the error from a call to PutMetricAlarm
+func CreateMetricAlarm(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error) {
+	return api.PutMetricAlarm(c, input)
+}
+
+// EnableAlarm enables the specified Amazon CloudWatch alarm
+// Inputs:
+//     c is the context of the method call, which includes the Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a EnableAlarmActionsOutput object containing the result of the service call and nil
+//     Otherwise, the error from a call to EnableAlarmActions
+func EnableAlarm(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.EnableAlarmActionsInput) (*cloudwatch.EnableAlarmActionsOutput, error) {
+	return api.EnableAlarmActions(c, input)
+}
+
+// DescribeAlarmsForPrefix returns the Amazon CloudWatch alarms selected by input, e.g. by its
+// AlarmNamePrefix or by exact AlarmNames; input is passed to DescribeAlarms unchanged
+// Inputs:
+//     c is the context of the method call, which includes the Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a DescribeAlarmsOutput object containing the result of the service call and nil
+//     Otherwise, the error from a call to DescribeAlarms
+func DescribeAlarmsForPrefix(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error) {
+	return api.DescribeAlarms(c, input)
+}
+
+// DeleteAlarmsByName deletes the specified Amazon CloudWatch alarms
+// Inputs:
+//     c is the context of the method call, which includes the Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a DeleteAlarmsOutput object containing the result of the service call and nil
+//     Otherwise, the error from a call to DeleteAlarms
+func DeleteAlarmsByName(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.DeleteAlarmsInput) (*cloudwatch.DeleteAlarmsOutput, error) {
+	return api.DeleteAlarms(c, input)
+}
+
+// PutComposite creates a composite alarm
+// Inputs:
+//     c is the context of the method call, which includes the Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a PutCompositeAlarmOutput object containing the result of the service call and nil
+//     Otherwise, the error from a call to PutCompositeAlarm
+func PutComposite(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.PutCompositeAlarmInput) (*cloudwatch.PutCompositeAlarmOutput, error) {
+	return api.PutCompositeAlarm(c, input)
+}
+
+// InstanceFilter narrows GetInstanceIds beyond name/tag-key/running: zero or more tag:key=value
+// filters, plus VPC, subnet, and instance-type filters.
+type InstanceFilter struct {
+	Tags         map[string]string // tag key/value pairs, each turned into its own tag:<key> filter
+	VPCID        string
+	SubnetID     string
+	InstanceType string
+}
+
+// EC2DescribeInstancesAPI defines the interface for the DescribeInstances function, which is all
+// ec2.NewDescribeInstancesPaginator needs. We use this interface to test GetInstanceIds using a
+// mocked service.
+type EC2DescribeInstancesAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// GetInstanceIds returns a list of instance IDs, paginating through DescribeInstances so fleets
+// over the 1000-instance single-page limit are covered.
+func GetInstanceIds(cfg aws.Config, name string, tagKey string, ids []string, running bool, filter InstanceFilter) ([]string, error) {
+	return getInstanceIds(context.TODO(), ec2.NewFromConfig(cfg), name, tagKey, ids, running, filter)
+}
+
+// getInstanceIds is GetInstanceIds's implementation against the EC2DescribeInstancesAPI interface,
+// so it can be exercised with a mocked service instead of a real ec2.Client.
+func getInstanceIds(ctx context.Context, api EC2DescribeInstancesAPI, name string, tagKey string, ids []string, running bool, filter InstanceFilter) ([]string, error) {
+	filters := make([]ec2types.Filter, 0, 4+len(filter.Tags))
+	if name != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("tag:Name"), Values: []string{name}})
+	}
+	if tagKey != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("tag-key"), Values: []string{tagKey}})
+	}
+	if running {
+		filters = append(filters, ec2types.Filter{Name: aws.String("instance-state-name"), Values: []string{"running"}})
+	}
+	for key, value := range filter.Tags {
+		filters = append(filters, ec2types.Filter{Name: aws.String("tag:" + key), Values: []string{value}})
+	}
+	if filter.VPCID != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("vpc-id"), Values: []string{filter.VPCID}})
+	}
+	if filter.SubnetID != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("subnet-id"), Values: []string{filter.SubnetID}})
+	}
+	if filter.InstanceType != "" {
+		filters = append(filters, ec2types.Filter{Name: aws.String("instance-type"), Values: []string{filter.InstanceType}})
+	}
+
+	describeInput := &ec2.DescribeInstancesInput{Filters: filters}
+	if ids[0] != "" {
+		describeInput.InstanceIds = ids
+	}
+
+	instacneIds := make([]string, 0)
+	paginator := ec2.NewDescribeInstancesPaginator(api, describeInput)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				fmt.Printf("%s (%s): %v\n", *instance.InstanceId, instance.InstanceType, instance.State.Name)
+				instacneIds = append(instacneIds, *instance.InstanceId)
+			}
+		}
+	}
+	return instacneIds, nil
+}
+
+// CreateAlarmForInstance creates and enables the metric alarm described by opts (CPUUtilization by
+// default) for a single EC2 instance. It is the library entry point shared by the CLI and the
+// Lambda handler: given an instance ID, the AWS account it belongs to, and the desired Options, it
+// first describes the existing alarm of that name; it returns without changes when that alarm
+// already matches (see diffMetricAlarm) or when opts.DryRun is set, and otherwise issues
+// PutMetricAlarm followed by EnableAlarmActions.
+func CreateAlarmForInstance(ctx context.Context, cfg aws.Config, instanceID string, account string, opts Options) error {
+	return createAlarmForInstance(ctx, cloudwatch.NewFromConfig(cfg), cfg.Region, instanceID, account, opts)
+}
+
+// createAlarmForInstance is CreateAlarmForInstance's implementation against the CWEnableAlarmAPI
+// interface, so it can be exercised with a mocked service instead of a real cloudwatch.Client.
+func createAlarmForInstance(ctx context.Context, api CWEnableAlarmAPI, region string, instanceID string, account string, opts Options) error {
+	opts = withDefaults(opts)
+
+	statistic, err := ValidateStatistic(opts.Statistic)
+	if err != nil {
+		return err
+	}
+	operator, err := ValidateOperator(opts.Operator)
+	if err != nil {
+		return err
+	}
+
+	alarmName := AlarmName(instanceID, opts)
+	putInput := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:          &alarmName,
+		ComparisonOperator: operator,
+		EvaluationPeriods:  aws.Int32(opts.EvaluationPeriods),
+		DatapointsToAlarm:  aws.Int32(opts.DatapointsToAlarm),
+		MetricName:         aws.String(opts.MetricName),
+		Namespace:          aws.String(opts.Namespace),
+		Period:             aws.Int32(opts.Period),
+		Statistic:          statistic,
+		Threshold:          aws.Float64(opts.Threshold),
+		ActionsEnabled:     aws.Bool(true),
+		AlarmDescription:   aws.String(fmt.Sprintf("Alarm when %s %s %f on instance %s", opts.MetricName, opts.Operator, opts.Threshold, instanceID)),
+		AlarmActions: []string{
+			fmt.Sprintf("arn:aws:swf:"+region+":%s:action/actions/AWS_EC2.InstanceId.%s/1.0", account, opts.Action),
+			fmt.Sprintf("arn:aws:sns:"+region+":%s:%s", account, opts.SNSTopic),
+		},
+		Dimensions: []types.Dimension{
+			{
+				Name:  aws.String("InstanceId"),
+				Value: &instanceID,
+			},
+		},
+	}
+	if opts.Unit != "" {
+		putInput.Unit = types.StandardUnit(opts.Unit)
+	}
+
+	describeOutput, err := DescribeAlarmsForPrefix(ctx, api, &cloudwatch.DescribeAlarmsInput{AlarmNames: []string{alarmName}})
+	if err != nil {
+		return err
+	}
+
+	var diffs []alarmDiff
+	if len(describeOutput.MetricAlarms) > 0 {
+		diffs = diffMetricAlarm(describeOutput.MetricAlarms[0], putInput)
+		if len(diffs) == 0 {
+			fmt.Println("Alarm " + alarmName + " already up to date for EC2 instance " + instanceID)
+			return nil
+		}
+	}
+
+	if opts.DryRun {
+		if len(describeOutput.MetricAlarms) == 0 {
+			fmt.Println("[dry-run] would create alarm " + alarmName + " for EC2 instance " + instanceID)
+		} else {
+			fmt.Println("[dry-run] would update alarm " + alarmName + " for EC2 instance " + instanceID + ":")
+			for _, d := range diffs {
+				fmt.Printf("  %s: %s -> %s\n", d.field, d.current, d.desired)
+			}
+		}
+		return nil
+	}
+
+	for _, d := range diffs {
+		log.Printf("alarm %s: %s changed (%s -> %s)", alarmName, d.field, d.current, d.desired)
+	}
+
+	if _, err := CreateMetricAlarm(ctx, api, putInput); err != nil {
+		return err
+	}
+
+	enableInput := &cloudwatch.EnableAlarmActionsInput{
+		AlarmNames: []string{
+			alarmName,
+		},
+	}
+
+	if _, err := EnableAlarm(ctx, api, enableInput); err != nil {
+		return err
+	}
+
+	fmt.Println("Enabled alarm " + alarmName + " for EC2 instance " + instanceID)
+	return nil
+}
+
+// alarmDiff describes one field that differs between an existing alarm and the desired input.
+type alarmDiff struct {
+	field   string
+	current string
+	desired string
+}
+
+// diffMetricAlarm compares an existing alarm to the desired PutMetricAlarmInput, returning one
+// alarmDiff per differing field among threshold, period, comparison operator, alarm actions,
+// dimensions, and whether actions are enabled, e.g. when CreateAlarmForInstance is re-run with a new
+// threshold, or when someone has disabled the alarm's actions out-of-band in the console. Namespace,
+// metric name, statistic, evaluation periods, datapoints to alarm, and unit are not compared, so a
+// change to only those is not detected. An empty result means the existing alarm already matches
+// and PutMetricAlarm can be skipped.
+func diffMetricAlarm(existing types.MetricAlarm, desired *cloudwatch.PutMetricAlarmInput) []alarmDiff {
+	var diffs []alarmDiff
+
+	if aws.ToBool(existing.ActionsEnabled) != aws.ToBool(desired.ActionsEnabled) {
+		diffs = append(diffs, alarmDiff{"actions enabled", fmt.Sprint(aws.ToBool(existing.ActionsEnabled)), fmt.Sprint(aws.ToBool(desired.ActionsEnabled))})
+	}
+	if aws.ToFloat64(existing.Threshold) != aws.ToFloat64(desired.Threshold) {
+		diffs = append(diffs, alarmDiff{"threshold", fmt.Sprint(aws.ToFloat64(existing.Threshold)), fmt.Sprint(aws.ToFloat64(desired.Threshold))})
+	}
+	if aws.ToInt32(existing.Period) != aws.ToInt32(desired.Period) {
+		diffs = append(diffs, alarmDiff{"period", fmt.Sprint(aws.ToInt32(existing.Period)), fmt.Sprint(aws.ToInt32(desired.Period))})
+	}
+	if existing.ComparisonOperator != desired.ComparisonOperator {
+		diffs = append(diffs, alarmDiff{"comparison operator", string(existing.ComparisonOperator), string(desired.ComparisonOperator)})
+	}
+	if !stringSlicesEqual(existing.AlarmActions, desired.AlarmActions) {
+		diffs = append(diffs, alarmDiff{"actions", strings.Join(existing.AlarmActions, ","), strings.Join(desired.AlarmActions, ",")})
+	}
+	if !dimensionsEqual(existing.Dimensions, desired.Dimensions) {
+		diffs = append(diffs, alarmDiff{"dimensions", dimensionsString(existing.Dimensions), dimensionsString(desired.Dimensions)})
+	}
+	return diffs
+}
+
+// stringSlicesEqual reports whether a and b contain the same strings, in any order.
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		seen[s]--
+	}
+	for _, count := range seen {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// dimensionsEqual reports whether a and b name the same set of (dimension name, value) pairs.
+func dimensionsEqual(a, b []types.Dimension) bool {
+	return dimensionsString(a) == dimensionsString(b)
+}
+
+// dimensionsString renders dimensions as a stable, comparable string for diffing and logging.
+func dimensionsString(dimensions []types.Dimension) string {
+	parts := make([]string, len(dimensions))
+	for i, d := range dimensions {
+		parts[i] = fmt.Sprintf("%s=%s", aws.ToString(d.Name), aws.ToString(d.Value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// CreateCompositeAlarm creates and enables a composite alarm for instanceID that combines
+// alarmNames, e.g. a CPUUtilization, a NetworkOut, and a StatusCheckFailed metric alarm ANDed
+// together before triggering opts.Action. If rule is empty, it defaults to ANDing every name in
+// alarmNames; otherwise rule is used verbatim as the AlarmRule expression.
+func CreateCompositeAlarm(ctx context.Context, cfg aws.Config, instanceID string, account string, opts Options, alarmNames []string, rule string) error {
+	return createCompositeAlarm(ctx, cloudwatch.NewFromConfig(cfg), cfg.Region, instanceID, account, opts, alarmNames, rule)
+}
+
+// createCompositeAlarm is CreateCompositeAlarm's implementation against the CWEnableAlarmAPI
+// interface, so it can be exercised with a mocked service instead of a real cloudwatch.Client.
+func createCompositeAlarm(ctx context.Context, api CWEnableAlarmAPI, region string, instanceID string, account string, opts Options, alarmNames []string, rule string) error {
+	if rule == "" {
+		clauses := make([]string, len(alarmNames))
+		for i, name := range alarmNames {
+			clauses[i] = fmt.Sprintf("ALARM(%q)", name)
+		}
+		rule = strings.Join(clauses, " AND ")
+	}
+
+	alarmName := Prefix + instanceID + "-" + opts.AlarmNamePrefix + "-composite"
+	putInput := &cloudwatch.PutCompositeAlarmInput{
+		AlarmName:      &alarmName,
+		AlarmRule:      &rule,
+		ActionsEnabled: aws.Bool(true),
+		AlarmActions: []string{
+			fmt.Sprintf("arn:aws:swf:"+region+":%s:action/actions/AWS_EC2.InstanceId.%s/1.0", account, opts.Action),
+			fmt.Sprintf("arn:aws:sns:"+region+":%s:%s", account, opts.SNSTopic),
+		},
+	}
+
+	if opts.DryRun {
+		fmt.Println("[dry-run] would create composite alarm " + alarmName + " for EC2 instance " + instanceID)
+		return nil
+	}
+
+	if _, err := PutComposite(ctx, api, putInput); err != nil {
+		return err
+	}
+
+	fmt.Println("Enabled composite alarm " + alarmName + " for EC2 instance " + instanceID)
+	return nil
+}
+
+// ApplyInstanceConfig creates every metric alarm declared in cfg.MetricAlarms for its instance,
+// then, if cfg.CompositeAlarm is set, creates a composite alarm combining them. This is the
+// config-file counterpart of CreateAlarmForInstance, letting several metric alarms and one
+// composite alarm be declared for an instance in a single run.
+func ApplyInstanceConfig(ctx context.Context, cfg aws.Config, account string, instCfg InstanceConfig, dryRun bool) error {
+	return applyInstanceConfig(ctx, cloudwatch.NewFromConfig(cfg), cfg.Region, account, instCfg, dryRun)
+}
+
+// applyInstanceConfig is ApplyInstanceConfig's implementation against the CWEnableAlarmAPI
+// interface, so it can be exercised with a mocked service instead of a real cloudwatch.Client.
+func applyInstanceConfig(ctx context.Context, api CWEnableAlarmAPI, region string, account string, instCfg InstanceConfig, dryRun bool) error {
+	names := make([]string, 0, len(instCfg.MetricAlarms))
+	for _, m := range instCfg.MetricAlarms {
+		opts := Options{
+			AlarmNamePrefix:   instCfg.AlarmNamePrefix,
+			NameSuffix:        metricConfigKey(m),
+			SNSTopic:          instCfg.SNSTopic,
+			Action:            instCfg.Action,
+			Threshold:         m.Threshold,
+			Period:            m.Period,
+			Namespace:         m.Namespace,
+			MetricName:        m.MetricName,
+			Statistic:         m.Statistic,
+			Operator:          m.Operator,
+			EvaluationPeriods: m.EvaluationPeriods,
+			DatapointsToAlarm: m.DatapointsToAlarm,
+			Unit:              m.Unit,
+			DryRun:            dryRun,
+		}
+		if err := createAlarmForInstance(ctx, api, region, instCfg.InstanceID, account, opts); err != nil {
+			return err
+		}
+		names = append(names, AlarmName(instCfg.InstanceID, opts))
+	}
+
+	if instCfg.CompositeAlarm != nil {
+		opts := Options{AlarmNamePrefix: instCfg.AlarmNamePrefix, SNSTopic: instCfg.SNSTopic, Action: instCfg.Action, DryRun: dryRun}
+		if err := createCompositeAlarm(ctx, api, region, instCfg.InstanceID, account, opts, names, instCfg.CompositeAlarm.Rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metricConfigKey returns the name distinguishing a MetricAlarmConfig within its InstanceConfig,
+// falling back to the metric name when Name is not set.
+func metricConfigKey(m MetricAlarmConfig) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.MetricName
+}
+
+// DeleteAlarmForInstance deletes the alarm this tool created for a single EC2 instance, e.g. once
+// the instance has been terminated or stopped.
+func DeleteAlarmForInstance(ctx context.Context, cfg aws.Config, instanceID string, opts Options) error {
+	return deleteAlarmForInstance(ctx, cloudwatch.NewFromConfig(cfg), instanceID, opts)
+}
+
+// deleteAlarmForInstance is DeleteAlarmForInstance's implementation against the CWEnableAlarmAPI
+// interface, so it can be exercised with a mocked service instead of a real cloudwatch.Client.
+func deleteAlarmForInstance(ctx context.Context, api CWEnableAlarmAPI, instanceID string, opts Options) error {
+	alarmName := AlarmName(instanceID, opts)
+	deleteInput := &cloudwatch.DeleteAlarmsInput{AlarmNames: []string{alarmName}}
+	if _, err := DeleteAlarmsByName(ctx, api, deleteInput); err != nil {
+		return err
+	}
+
+	fmt.Println("Deleted alarm " + alarmName + " for EC2 instance " + instanceID)
+	return nil
+}
+
+// ReconcileAlarms creates/updates the metric alarms for the given instance IDs and deletes any
+// alarm under Prefix whose instance no longer appears in ids, scoped to opts.AlarmNamePrefix so
+// that a different topology (e.g. another team's fleet) sharing the same account/region is left
+// alone. This keeps the set of alarms in sync with a fleet whose instances come and go, e.g. an
+// AutoScaling group, instead of only ever accumulating alarms. It only considers metric alarms:
+// composite alarms created via the config-file path (CreateCompositeAlarm/ApplyInstanceConfig)
+// are not tracked here and are never pruned by reconcile.
+func ReconcileAlarms(ctx context.Context, cfg aws.Config, ids []string, account string, opts Options) error {
+	return reconcileAlarms(ctx, cloudwatch.NewFromConfig(cfg), cfg.Region, ids, account, opts)
+}
+
+// reconcileAlarms is ReconcileAlarms's implementation against the CWEnableAlarmAPI interface, so
+// it can be exercised with a mocked service instead of a real cloudwatch.Client.
+func reconcileAlarms(ctx context.Context, api CWEnableAlarmAPI, region string, ids []string, account string, opts Options) error {
+	desired := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		desired[AlarmName(id, opts)] = true
+	}
+	topologySuffix := "-" + opts.AlarmNamePrefix
+
+	var alarms []types.MetricAlarm
+	describeInput := &cloudwatch.DescribeAlarmsInput{
+		AlarmNamePrefix: aws.String(Prefix),
+	}
+	for {
+		output, err := DescribeAlarmsForPrefix(ctx, api, describeInput)
+		if err != nil {
+			return fmt.Errorf("describe alarms error: %w", err)
+		}
+		alarms = append(alarms, output.MetricAlarms...)
+		if output.NextToken == nil {
+			break
+		}
+		describeInput.NextToken = output.NextToken
+	}
+
+	stale := make([]string, 0)
+	for _, a := range alarms {
+		if !strings.HasSuffix(*a.AlarmName, topologySuffix) {
+			continue
+		}
+		if !desired[*a.AlarmName] {
+			stale = append(stale, *a.AlarmName)
+		}
+	}
+
+	if len(stale) > 0 {
+		if opts.DryRun {
+			for _, name := range stale {
+				fmt.Println("[dry-run] would delete stale alarm " + name)
+			}
+		} else {
+			deleteInput := &cloudwatch.DeleteAlarmsInput{AlarmNames: stale}
+			if _, err := DeleteAlarmsByName(ctx, api, deleteInput); err != nil {
+				fmt.Println(err)
+			} else {
+				for _, name := range stale {
+					fmt.Println("Deleted stale alarm " + name)
+				}
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if err := createAlarmForInstance(ctx, api, region, id, account, opts); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+
+package alarm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// mockCWEnableAlarmAPI is the mocked service referred to by CWEnableAlarmAPI's doc comment.
+type mockCWEnableAlarmAPI struct {
+	describeOutput    *cloudwatch.DescribeAlarmsOutput
+	describePages     []*cloudwatch.DescribeAlarmsOutput // paginated prefix-describe results, consumed in order
+	describeErr       error
+	deleteErr         error
+	deletedNames      []string
+	putMetricCalls    int
+	putCompositeCalls int
+	lastCompositeRule string
+}
+
+func (m *mockCWEnableAlarmAPI) PutMetricAlarm(ctx context.Context, params *cloudwatch.PutMetricAlarmInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error) {
+	m.putMetricCalls++
+	return &cloudwatch.PutMetricAlarmOutput{}, nil
+}
+
+func (m *mockCWEnableAlarmAPI) EnableAlarmActions(ctx context.Context, params *cloudwatch.EnableAlarmActionsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.EnableAlarmActionsOutput, error) {
+	return &cloudwatch.EnableAlarmActionsOutput{}, nil
+}
+
+func (m *mockCWEnableAlarmAPI) DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error) {
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+	if len(params.AlarmNames) > 0 {
+		var out []types.MetricAlarm
+		if m.describeOutput != nil {
+			for _, a := range m.describeOutput.MetricAlarms {
+				if aws.ToString(a.AlarmName) == params.AlarmNames[0] {
+					out = append(out, a)
+				}
+			}
+		}
+		return &cloudwatch.DescribeAlarmsOutput{MetricAlarms: out}, nil
+	}
+	if m.describePages != nil {
+		page := 0
+		if params.NextToken != nil {
+			page, _ = strconv.Atoi(*params.NextToken)
+		}
+		return m.describePages[page], nil
+	}
+	return m.describeOutput, nil
+}
+
+func (m *mockCWEnableAlarmAPI) DeleteAlarms(ctx context.Context, params *cloudwatch.DeleteAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error) {
+	if m.deleteErr != nil {
+		return nil, m.deleteErr
+	}
+	m.deletedNames = append(m.deletedNames, params.AlarmNames...)
+	return &cloudwatch.DeleteAlarmsOutput{}, nil
+}
+
+func (m *mockCWEnableAlarmAPI) PutCompositeAlarm(ctx context.Context, params *cloudwatch.PutCompositeAlarmInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutCompositeAlarmOutput, error) {
+	m.putCompositeCalls++
+	m.lastCompositeRule = aws.ToString(params.AlarmRule)
+	return &cloudwatch.PutCompositeAlarmOutput{}, nil
+}
+
+func TestDescribeAlarmsForPrefixPropagatesError(t *testing.T) {
+	wantErr := errors.New("throttled")
+	api := &mockCWEnableAlarmAPI{describeErr: wantErr}
+
+	_, err := DescribeAlarmsForPrefix(context.Background(), api, &cloudwatch.DescribeAlarmsInput{})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("DescribeAlarmsForPrefix() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestDeleteAlarmsByName(t *testing.T) {
+	api := &mockCWEnableAlarmAPI{}
+
+	_, err := DeleteAlarmsByName(context.Background(), api, &cloudwatch.DeleteAlarmsInput{AlarmNames: []string{"awsec2-i-1234-stale"}})
+	if err != nil {
+		t.Fatalf("DeleteAlarmsByName() error = %v, want nil", err)
+	}
+	if !stringSlicesEqual(api.deletedNames, []string{"awsec2-i-1234-stale"}) {
+		t.Fatalf("deletedNames = %v, want [awsec2-i-1234-stale]", api.deletedNames)
+	}
+}
+
+func TestDiffMetricAlarmNoDiff(t *testing.T) {
+	instanceID := "i-1234567890abcdef0"
+	existing := types.MetricAlarm{
+		ActionsEnabled:     aws.Bool(true),
+		Threshold:          aws.Float64(1.0),
+		Period:             aws.Int32(900),
+		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:topic"},
+		Dimensions: []types.Dimension{
+			{Name: aws.String("InstanceId"), Value: &instanceID},
+		},
+	}
+	desired := &cloudwatch.PutMetricAlarmInput{
+		ActionsEnabled:     aws.Bool(true),
+		Threshold:          aws.Float64(1.0),
+		Period:             aws.Int32(900),
+		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:topic"},
+		Dimensions: []types.Dimension{
+			{Name: aws.String("InstanceId"), Value: &instanceID},
+		},
+	}
+
+	if diffs := diffMetricAlarm(existing, desired); len(diffs) != 0 {
+		t.Fatalf("diffMetricAlarm() = %v, want no diffs", diffs)
+	}
+}
+
+func TestDiffMetricAlarmDetectsDisabledActions(t *testing.T) {
+	instanceID := "i-1234567890abcdef0"
+	existing := types.MetricAlarm{
+		ActionsEnabled:     aws.Bool(false), // manually disabled in the console
+		Threshold:          aws.Float64(1.0),
+		Period:             aws.Int32(900),
+		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:topic"},
+		Dimensions: []types.Dimension{
+			{Name: aws.String("InstanceId"), Value: &instanceID},
+		},
+	}
+	desired := &cloudwatch.PutMetricAlarmInput{
+		ActionsEnabled:     aws.Bool(true),
+		Threshold:          aws.Float64(1.0),
+		Period:             aws.Int32(900),
+		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:topic"},
+		Dimensions: []types.Dimension{
+			{Name: aws.String("InstanceId"), Value: &instanceID},
+		},
+	}
+
+	diffs := diffMetricAlarm(existing, desired)
+	if len(diffs) != 1 || diffs[0].field != "actions enabled" {
+		t.Fatalf("diffMetricAlarm() = %v, want single 'actions enabled' diff", diffs)
+	}
+}
+
+func TestCreateAlarmForInstanceReEnablesDisabledAlarm(t *testing.T) {
+	instanceID := "i-100"
+	opts := testOpts()
+	alarmName := AlarmName(instanceID, opts)
+
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []types.MetricAlarm{{
+			AlarmName:          &alarmName,
+			ActionsEnabled:     aws.Bool(false),
+			Threshold:          aws.Float64(1.0),
+			Period:             aws.Int32(900),
+			ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+			AlarmActions: []string{
+				"arn:aws:swf:us-east-1:111111111111:action/actions/AWS_EC2.InstanceId.Terminate/1.0",
+				"arn:aws:sns:us-east-1:111111111111:topic",
+			},
+			Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: &instanceID}},
+		}},
+	}}
+
+	if err := createAlarmForInstance(context.Background(), api, "us-east-1", instanceID, "111111111111", opts); err != nil {
+		t.Fatalf("createAlarmForInstance() error = %v, want nil", err)
+	}
+	if api.putMetricCalls != 1 {
+		t.Fatalf("putMetricCalls = %d, want 1 (must re-enable a disabled alarm)", api.putMetricCalls)
+	}
+}
+
+func TestDiffMetricAlarmDetectsChangedFields(t *testing.T) {
+	existing := types.MetricAlarm{
+		Threshold:          aws.Float64(1.0),
+		Period:             aws.Int32(900),
+		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:topic"},
+	}
+	desired := &cloudwatch.PutMetricAlarmInput{
+		Threshold:          aws.Float64(5.0),
+		Period:             aws.Int32(60),
+		ComparisonOperator: types.ComparisonOperatorGreaterThanThreshold,
+		AlarmActions:       []string{"arn:aws:sns:us-east-1:111111111111:other-topic"},
+	}
+
+	diffs := diffMetricAlarm(existing, desired)
+	if len(diffs) != 4 {
+		t.Fatalf("diffMetricAlarm() returned %d diffs, want 4: %v", len(diffs), diffs)
+	}
+}
+
+func testOpts() Options {
+	return Options{AlarmNamePrefix: "myteam", SNSTopic: "topic", Action: "Terminate", Threshold: 1.0, Period: 900}
+}
+
+func TestCreateAlarmForInstanceSkipsWhenUnchanged(t *testing.T) {
+	instanceID := "i-100"
+	opts := testOpts()
+	alarmName := AlarmName(instanceID, opts)
+
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []types.MetricAlarm{{
+			AlarmName:          &alarmName,
+			ActionsEnabled:     aws.Bool(true),
+			Threshold:          aws.Float64(1.0),
+			Period:             aws.Int32(900),
+			ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
+			AlarmActions: []string{
+				"arn:aws:swf:us-east-1:111111111111:action/actions/AWS_EC2.InstanceId.Terminate/1.0",
+				"arn:aws:sns:us-east-1:111111111111:topic",
+			},
+			Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: &instanceID}},
+		}},
+	}}
+
+	if err := createAlarmForInstance(context.Background(), api, "us-east-1", instanceID, "111111111111", opts); err != nil {
+		t.Fatalf("createAlarmForInstance() error = %v, want nil", err)
+	}
+	if api.putMetricCalls != 0 {
+		t.Fatalf("putMetricCalls = %d, want 0 (alarm already up to date)", api.putMetricCalls)
+	}
+}
+
+func TestCreateAlarmForInstanceDryRunDoesNotMutate(t *testing.T) {
+	instanceID := "i-100"
+	opts := testOpts()
+	opts.DryRun = true
+
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{}}
+
+	if err := createAlarmForInstance(context.Background(), api, "us-east-1", instanceID, "111111111111", opts); err != nil {
+		t.Fatalf("createAlarmForInstance() error = %v, want nil", err)
+	}
+	if api.putMetricCalls != 0 {
+		t.Fatalf("putMetricCalls = %d, want 0 (dry-run must not mutate)", api.putMetricCalls)
+	}
+}
+
+func TestReconcileAlarmsDeletesOnlyStaleWithinTopology(t *testing.T) {
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []types.MetricAlarm{
+			{AlarmName: aws.String("awsec2-i-999-myteam")},    // stale: same topology, not in ids
+			{AlarmName: aws.String("awsec2-i-200-otherteam")}, // different topology: must survive
+		},
+	}}
+
+	opts := testOpts()
+	if err := reconcileAlarms(context.Background(), api, "us-east-1", []string{"i-100"}, "111111111111", opts); err != nil {
+		t.Fatalf("reconcileAlarms() error = %v, want nil", err)
+	}
+
+	if !stringSlicesEqual(api.deletedNames, []string{"awsec2-i-999-myteam"}) {
+		t.Fatalf("deletedNames = %v, want [awsec2-i-999-myteam]", api.deletedNames)
+	}
+}
+
+func TestDeleteAlarmForInstance(t *testing.T) {
+	instanceID := "i-100"
+	opts := testOpts()
+	api := &mockCWEnableAlarmAPI{}
+
+	if err := deleteAlarmForInstance(context.Background(), api, instanceID, opts); err != nil {
+		t.Fatalf("deleteAlarmForInstance() error = %v, want nil", err)
+	}
+	if want := AlarmName(instanceID, opts); !stringSlicesEqual(api.deletedNames, []string{want}) {
+		t.Fatalf("deletedNames = %v, want [%s]", api.deletedNames, want)
+	}
+}
+
+func TestApplyInstanceConfigCreatesMetricAndCompositeAlarms(t *testing.T) {
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{}}
+
+	instCfg := InstanceConfig{
+		InstanceID:      "i-100",
+		AlarmNamePrefix: "myteam",
+		SNSTopic:        "topic",
+		Action:          "Terminate",
+		MetricAlarms: []MetricAlarmConfig{
+			{Name: "cpu", MetricName: "CPUUtilization", Statistic: "Average", Operator: "LessThanThreshold", Threshold: 1.0, Period: 900, EvaluationPeriods: 1},
+			{Name: "net", MetricName: "NetworkOut", Statistic: "Average", Operator: "GreaterThanThreshold", Threshold: 100, Period: 300, EvaluationPeriods: 1},
+		},
+		CompositeAlarm: &CompositeAlarmConfig{},
+	}
+
+	if err := applyInstanceConfig(context.Background(), api, "us-east-1", "111111111111", instCfg, false); err != nil {
+		t.Fatalf("applyInstanceConfig() error = %v, want nil", err)
+	}
+	if api.putMetricCalls != 2 {
+		t.Fatalf("putMetricCalls = %d, want 2", api.putMetricCalls)
+	}
+	if api.putCompositeCalls != 1 {
+		t.Fatalf("putCompositeCalls = %d, want 1", api.putCompositeCalls)
+	}
+}
+
+func TestApplyInstanceConfigDefaultRuleANDsMetricAlarms(t *testing.T) {
+	instCfg := InstanceConfig{
+		InstanceID:      "i-100",
+		AlarmNamePrefix: "myteam",
+		MetricAlarms: []MetricAlarmConfig{
+			{Name: "cpu", MetricName: "CPUUtilization", Statistic: "Average", Operator: "LessThanThreshold", Threshold: 1.0, Period: 900, EvaluationPeriods: 1},
+			{Name: "net", MetricName: "NetworkOut", Statistic: "Average", Operator: "GreaterThanThreshold", Threshold: 100, Period: 300, EvaluationPeriods: 1},
+		},
+		CompositeAlarm: &CompositeAlarmConfig{},
+	}
+	names := []string{AlarmName("i-100", Options{AlarmNamePrefix: "myteam", NameSuffix: "cpu"}), AlarmName("i-100", Options{AlarmNamePrefix: "myteam", NameSuffix: "net"})}
+	wantRule := `ALARM("` + names[0] + `") AND ALARM("` + names[1] + `")`
+
+	api := &mockCWEnableAlarmAPI{}
+	if err := applyInstanceConfig(context.Background(), api, "us-east-1", "111111111111", instCfg, false); err != nil {
+		t.Fatalf("applyInstanceConfig() error = %v, want nil", err)
+	}
+	if api.lastCompositeRule != wantRule {
+		t.Fatalf("AlarmRule = %q, want %q", api.lastCompositeRule, wantRule)
+	}
+}
+
+func TestReconcileAlarmsFollowsPagination(t *testing.T) {
+	api := &mockCWEnableAlarmAPI{describePages: []*cloudwatch.DescribeAlarmsOutput{
+		{
+			MetricAlarms: []types.MetricAlarm{{AlarmName: aws.String("awsec2-i-999-myteam")}}, // stale: page 1
+			NextToken:    aws.String("1"),
+		},
+		{
+			MetricAlarms: []types.MetricAlarm{{AlarmName: aws.String("awsec2-i-998-myteam")}}, // stale: page 2
+		},
+	}}
+
+	opts := testOpts()
+	if err := reconcileAlarms(context.Background(), api, "us-east-1", []string{"i-100"}, "111111111111", opts); err != nil {
+		t.Fatalf("reconcileAlarms() error = %v, want nil", err)
+	}
+
+	if !stringSlicesEqual(api.deletedNames, []string{"awsec2-i-999-myteam", "awsec2-i-998-myteam"}) {
+		t.Fatalf("deletedNames = %v, want both pages' stale alarms", api.deletedNames)
+	}
+}
+
+func TestReconcileAlarmsDryRunDoesNotDelete(t *testing.T) {
+	api := &mockCWEnableAlarmAPI{describeOutput: &cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []types.MetricAlarm{
+			{AlarmName: aws.String("awsec2-i-999-myteam")},
+		},
+	}}
+
+	opts := testOpts()
+	opts.DryRun = true
+	if err := reconcileAlarms(context.Background(), api, "us-east-1", []string{"i-100"}, "111111111111", opts); err != nil {
+		t.Fatalf("reconcileAlarms() error = %v, want nil", err)
+	}
+
+	if len(api.deletedNames) != 0 {
+		t.Fatalf("deletedNames = %v, want none (dry-run must not delete)", api.deletedNames)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+package alarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InstanceConfig declares the metric alarms, and optionally one composite alarm, to create for a
+// single EC2 instance. A config file passed via -config is a list of these, letting one run of
+// the tool declare a full alarm topology instead of the single CPU alarm the flags create.
+type InstanceConfig struct {
+	InstanceID      string                `json:"instanceId" yaml:"instanceId"`
+	AlarmNamePrefix string                `json:"alarmNamePrefix" yaml:"alarmNamePrefix"`
+	SNSTopic        string                `json:"snsTopic" yaml:"snsTopic"`
+	Action          string                `json:"action" yaml:"action"`
+	MetricAlarms    []MetricAlarmConfig   `json:"metricAlarms" yaml:"metricAlarms"`
+	CompositeAlarm  *CompositeAlarmConfig `json:"compositeAlarm,omitempty" yaml:"compositeAlarm,omitempty"`
+}
+
+// MetricAlarmConfig is one metric alarm within an InstanceConfig. Name distinguishes it from
+// other metric alarms declared for the same instance when set; otherwise MetricName is used.
+type MetricAlarmConfig struct {
+	Name              string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Namespace         string  `json:"namespace" yaml:"namespace"`
+	MetricName        string  `json:"metric" yaml:"metric"`
+	Statistic         string  `json:"statistic" yaml:"statistic"`
+	Operator          string  `json:"operator" yaml:"operator"`
+	Threshold         float64 `json:"threshold" yaml:"threshold"`
+	Period            int32   `json:"period" yaml:"period"`
+	EvaluationPeriods int32   `json:"evaluationPeriods" yaml:"evaluationPeriods"`
+	DatapointsToAlarm int32   `json:"datapointsToAlarm,omitempty" yaml:"datapointsToAlarm,omitempty"`
+	Unit              string  `json:"unit,omitempty" yaml:"unit,omitempty"`
+}
+
+// CompositeAlarmConfig declares a composite alarm combining the MetricAlarms of the same
+// InstanceConfig. Rule is an explicit AlarmRule expression, e.g. `ALARM("a") AND ALARM("b")`; if
+// empty, the rule ANDs together every metric alarm declared alongside it.
+type CompositeAlarmConfig struct {
+	Rule string `json:"rule,omitempty" yaml:"rule,omitempty"`
+}
+
+// LoadConfigFile reads a JSON or YAML file (chosen by its extension) declaring the alarms for one
+// or more instances.
+func LoadConfigFile(path string) ([]InstanceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []InstanceConfig
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		err = yaml.Unmarshal(data, &configs)
+	} else {
+		err = json.Unmarshal(data, &configs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return configs, nil
+}
@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+
+package alarm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testInstanceConfig() []InstanceConfig {
+	return []InstanceConfig{
+		{
+			InstanceID:      "i-100",
+			AlarmNamePrefix: "myteam",
+			SNSTopic:        "topic",
+			Action:          "Terminate",
+			MetricAlarms: []MetricAlarmConfig{
+				{Name: "cpu", MetricName: "CPUUtilization", Statistic: "Average", Operator: "LessThanThreshold", Threshold: 1.0, Period: 900, EvaluationPeriods: 1},
+				{Name: "net", MetricName: "NetworkOut", Statistic: "Average", Operator: "GreaterThanThreshold", Threshold: 100, Period: 300, EvaluationPeriods: 2},
+			},
+			CompositeAlarm: &CompositeAlarmConfig{},
+		},
+	}
+}
+
+func TestLoadConfigFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, testInstanceConfig())
+
+	configs, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() error = %v, want nil", err)
+	}
+	assertConfigRoundTrips(t, configs)
+}
+
+func TestLoadConfigFileYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, testInstanceConfig())
+
+	configs, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() error = %v, want nil", err)
+	}
+	assertConfigRoundTrips(t, configs)
+}
+
+// writeConfigFile marshals configs to path's extension-appropriate format (JSON or YAML), matching
+// LoadConfigFile's own format dispatch, and writes it for the test to read back.
+func writeConfigFile(t *testing.T, path string, configs []InstanceConfig) {
+	t.Helper()
+	var data []byte
+	var err error
+	if filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" {
+		data, err = yaml.Marshal(configs)
+	} else {
+		data, err = json.Marshal(configs)
+	}
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func assertConfigRoundTrips(t *testing.T, configs []InstanceConfig) {
+	t.Helper()
+	if len(configs) != 1 {
+		t.Fatalf("len(configs) = %d, want 1", len(configs))
+	}
+	cfg := configs[0]
+	if cfg.InstanceID != "i-100" || cfg.AlarmNamePrefix != "myteam" {
+		t.Fatalf("configs[0] = %+v, want InstanceID i-100, AlarmNamePrefix myteam", cfg)
+	}
+	if len(cfg.MetricAlarms) != 2 {
+		t.Fatalf("len(MetricAlarms) = %d, want 2", len(cfg.MetricAlarms))
+	}
+	if cfg.CompositeAlarm == nil {
+		t.Fatal("CompositeAlarm = nil, want non-nil")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+
+package alarm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// mockEC2DescribeInstancesAPI is the mocked service referred to by EC2DescribeInstancesAPI's doc
+// comment. It returns pages in order, one per call, and records the filters it was asked for.
+type mockEC2DescribeInstancesAPI struct {
+	pages     []*ec2.DescribeInstancesOutput
+	callCount int
+	lastInput *ec2.DescribeInstancesInput
+}
+
+func (m *mockEC2DescribeInstancesAPI) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	m.lastInput = params
+	page := m.pages[m.callCount]
+	m.callCount++
+	return page, nil
+}
+
+func instanceWithID(id string) types.Instance {
+	return types.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: types.InstanceTypeT2Micro,
+		State:        &types.InstanceState{Name: types.InstanceStateNameRunning},
+	}
+}
+
+func TestGetInstanceIdsFollowsPagination(t *testing.T) {
+	api := &mockEC2DescribeInstancesAPI{pages: []*ec2.DescribeInstancesOutput{
+		{
+			Reservations: []types.Reservation{{Instances: []types.Instance{instanceWithID("i-1")}}},
+			NextToken:    aws.String("1"),
+		},
+		{
+			Reservations: []types.Reservation{{Instances: []types.Instance{instanceWithID("i-2")}}},
+		},
+	}}
+
+	ids, err := getInstanceIds(context.Background(), api, "", "", []string{""}, false, InstanceFilter{})
+	if err != nil {
+		t.Fatalf("getInstanceIds() error = %v, want nil", err)
+	}
+	if !stringSlicesEqual(ids, []string{"i-1", "i-2"}) {
+		t.Fatalf("ids = %v, want [i-1 i-2]", ids)
+	}
+	if api.callCount != 2 {
+		t.Fatalf("callCount = %d, want 2", api.callCount)
+	}
+}
+
+func TestGetInstanceIdsBuildsFilters(t *testing.T) {
+	api := &mockEC2DescribeInstancesAPI{pages: []*ec2.DescribeInstancesOutput{{}}}
+
+	filter := InstanceFilter{
+		Tags:         map[string]string{"env": "prod"},
+		VPCID:        "vpc-1",
+		SubnetID:     "subnet-1",
+		InstanceType: "t2.micro",
+	}
+	if _, err := getInstanceIds(context.Background(), api, "myname", "myteam", []string{""}, true, filter); err != nil {
+		t.Fatalf("getInstanceIds() error = %v, want nil", err)
+	}
+
+	want := map[string][]string{
+		"tag:Name":            {"myname"},
+		"tag-key":             {"myteam"},
+		"instance-state-name": {"running"},
+		"tag:env":             {"prod"},
+		"vpc-id":              {"vpc-1"},
+		"subnet-id":           {"subnet-1"},
+		"instance-type":       {"t2.micro"},
+	}
+	got := make(map[string][]string, len(api.lastInput.Filters))
+	for _, f := range api.lastInput.Filters {
+		got[aws.ToString(f.Name)] = f.Values
+	}
+	if len(got) != len(want) {
+		t.Fatalf("filters = %v, want %v", got, want)
+	}
+	for name, values := range want {
+		if !stringSlicesEqual(got[name], values) {
+			t.Fatalf("filter %q = %v, want %v", name, got[name], values)
+		}
+	}
+}
+
+func TestGetInstanceIdsPassesExplicitInstanceIDs(t *testing.T) {
+	api := &mockEC2DescribeInstancesAPI{pages: []*ec2.DescribeInstancesOutput{{}}}
+
+	ids := []string{"i-1", "i-2"}
+	if _, err := getInstanceIds(context.Background(), api, "", "", ids, false, InstanceFilter{}); err != nil {
+		t.Fatalf("getInstanceIds() error = %v, want nil", err)
+	}
+	if !stringSlicesEqual(api.lastInput.InstanceIds, ids) {
+		t.Fatalf("InstanceIds = %v, want %v", api.lastInput.InstanceIds, ids)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+package alarm
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// ConfigForTarget returns an aws.Config scoped to region, and, if roleArn is non-empty, with
+// credentials assumed via STS AssumeRole into that role. This lets a single invocation fan out
+// across accounts and regions instead of only ever touching the caller's own account/region.
+func ConfigForTarget(baseCfg aws.Config, roleArn, region string) aws.Config {
+	cfg := baseCfg.Copy()
+	cfg.Region = region
+	if roleArn != "" {
+		stsClient := sts.NewFromConfig(baseCfg)
+		provider := stscreds.NewAssumeRoleProvider(stsClient, roleArn)
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg
+}
+
+// ListAllRegions returns every region enabled for the caller's account, for the "-regions all"
+// case where the caller wants to cover their whole fleet without naming each region.
+func ListAllRegions(ctx context.Context, cfg aws.Config) ([]string, error) {
+	client := ec2.NewFromConfig(cfg)
+	output, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]string, 0, len(output.Regions))
+	for _, r := range output.Regions {
+		regions = append(regions, *r.RegionName)
+	}
+	return regions, nil
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX - License - Identifier: Apache - 2.0
+
+// Command lambda is an alternative entry point to the alarm package: instead of a one-shot CLI
+// invocation, it runs as an AWS Lambda function triggered by EC2 Instance State-change
+// Notification events, creating an alarm when an instance starts running and deleting it when
+// the instance stops or terminates. Since flags aren't available in Lambda, it is configured
+// entirely through environment variables.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/a7420174/stop-ec2-cloudwatch/alarm"
+)
+
+// ec2StateChangeDetail is the "detail" field of an EC2 Instance State-change Notification event,
+// e.g. {"instance-id": "i-1234567890abcdef0", "state": "running"}.
+type ec2StateChangeDetail struct {
+	InstanceID string `json:"instance-id"`
+	State      string `json:"state"`
+}
+
+// optsFromEnv builds the alarm Options from environment variables, since flags aren't available
+// in Lambda. The handler calls it on every invocation.
+func optsFromEnv() alarm.Options {
+	threshold, err := strconv.ParseFloat(getenvOrDefault("ALARM_THRESHOLD", "1.0"), 64)
+	if err != nil {
+		log.Fatalln("invalid ALARM_THRESHOLD: " + err.Error())
+	}
+	period, err := strconv.Atoi(getenvOrDefault("ALARM_PERIOD", "900"))
+	if err != nil {
+		log.Fatalln("invalid ALARM_PERIOD: " + err.Error())
+	}
+
+	return alarm.Options{
+		AlarmNamePrefix: os.Getenv("ALARM_NAME_PREFIX"),
+		SNSTopic:        os.Getenv("SNS_TOPIC"),
+		Action:          getenvOrDefault("ALARM_ACTION", "Terminate"),
+		Threshold:       threshold,
+		Period:          int32(period),
+	}
+}
+
+func getenvOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// handler creates or deletes the alarm for the instance named in an EC2 Instance State-change
+// Notification event, depending on its new state.
+func handler(ctx context.Context, event events.CloudWatchEvent) error {
+	var detail ec2StateChangeDetail
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return fmt.Errorf("unmarshal detail: %w", err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+
+	opts := optsFromEnv()
+
+	switch detail.State {
+	case "running":
+		stssvc := sts.NewFromConfig(cfg)
+		output, err := stssvc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return fmt.Errorf("sts error: %w", err)
+		}
+		return alarm.CreateAlarmForInstance(ctx, cfg, detail.InstanceID, *output.Account, opts)
+	case "terminated", "stopped":
+		return alarm.DeleteAlarmForInstance(ctx, cfg, detail.InstanceID, opts)
+	default:
+		fmt.Printf("ignoring instance %s state %s\n", detail.InstanceID, detail.State)
+		return nil
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -7,163 +7,61 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
-)
 
-// CWEnableAlarmAPI defines the interface for the PutMetricAlarm function.
-// We use this interface to test the function using a mocked service.
-type CWEnableAlarmAPI interface {
-	PutMetricAlarm(ctx context.Context,
-		params *cloudwatch.PutMetricAlarmInput,
-		optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error)
-	EnableAlarmActions(ctx context.Context,
-		params *cloudwatch.EnableAlarmActionsInput,
-		optFns ...func(*cloudwatch.Options)) (*cloudwatch.EnableAlarmActionsOutput, error)
-}
+	"github.com/a7420174/stop-ec2-cloudwatch/alarm"
+)
 
 var (
-	instanceName    string
-	tagKey          string
-	instanceIDs     string
-	alarmNamePrefix string
-	running         bool
-	snsTopic        string // Default_CloudWatch_Alarms_Topic
-	action          string // Terminate, Stop, Reboot
-	threshold       float64   // 0-100
-	period          int 	 // 1, 5, 10, 30, or multiples of 60
+	instanceName      string
+	tagKey            string
+	instanceIDs       string
+	alarmNamePrefix   string
+	running           bool
+	snsTopic          string // Default_CloudWatch_Alarms_Topic
+	action            string // Terminate, Stop, Reboot
+	threshold         float64 // 0-100
+	period            int     // 1, 5, 10, 30, or multiples of 60
+	mode              string  // create or reconcile
+	namespace         string  // CloudWatch namespace, e.g. AWS/EC2
+	metricName        string  // CloudWatch metric name, e.g. CPUUtilization
+	statistic         string  // Average, Sum, SampleCount, Minimum, or Maximum
+	operator          string  // comparison operator, e.g. LessThanThreshold
+	evaluationPeriods int     // number of periods to evaluate
+	datapointsToAlarm int     // number of datapoints within evaluationPeriods that must breach
+	unit              string  // CloudWatch unit, e.g. Percent
+	configPath        string  // path to a YAML/JSON config file declaring alarms per instance
+	regionsFlag       string  // comma list of regions, or "all"
+	roleArnsFlag      string  // comma list of IAM role ARNs to AssumeRole into, one per account
+	concurrency       int     // bounded worker pool size across (account, region) targets
+	dryRun            bool    // print the intended diff instead of mutating anything
+	tagFlag           = make(tagFilters)
+	vpcID             string // VPC ID filter
+	subnetID          string // subnet ID filter
+	instanceType      string // EC2 instance type filter
 )
 
-// CreateMetricAlarm creates a metric alarm
-// Inputs:
-//     c is the context of the method call, which includes the Region
-//     api is the interface that defines the method call
-//     input defines the input arguments to the service call.
-// Output:
-//     If success, a PutMetricAlarmOutput object containing the result of the service call and nil
-//     Otherwise, the error from a call to PutMetricAlarm
-func CreateMetricAlarm(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error) {
-	return api.PutMetricAlarm(c, input)
-}
+// tagFilters accumulates repeated -tag key=value flags into a map, implementing flag.Value since
+// the standard flag package has no native repeatable-flag type.
+type tagFilters map[string]string
 
-// EnableAlarm enables the specified Amazon CloudWatch alarm
-// Inputs:
-//     c is the context of the method call, which includes the Region
-//     api is the interface that defines the method call
-//     input defines the input arguments to the service call.
-// Output:
-//     If success, a EnableAlarmActionsOutput object containing the result of the service call and nil
-//     Otherwise, the error from a call to PutMetricAlarm
-func EnableAlarm(c context.Context, api CWEnableAlarmAPI, input *cloudwatch.EnableAlarmActionsInput) (*cloudwatch.EnableAlarmActionsOutput, error) {
-	return api.EnableAlarmActions(c, input)
+func (t tagFilters) String() string {
+	return fmt.Sprint(map[string]string(t))
 }
 
-// GetInstanceIds returns a list of instance IDs
-func GetInstanceIds(cfg aws.Config, name string, tagKey string, ids []string, running bool) []string {
-	client := ec2.NewFromConfig(cfg)
-
-	var filterName, filterTag, filterStatus ec2types.Filter
-	if name != "" {
-		tag1 := "tag:Name"
-		filterName = ec2types.Filter{
-			Name:   &tag1,
-			Values: []string{name},
-		}
-	}
-
-	if tagKey != "" {
-		tag2 := "tag-key"
-		filterTag = ec2types.Filter{
-			Name:   &tag2,
-			Values: []string{tagKey},
-		}
-	}
-
-	if running {
-		tag3 := "instance-state-name"
-		filterStatus = ec2types.Filter{
-			Name:   &tag3,
-			Values: []string{"running"},
-		}
-	}
-
-	var (
-		outputs *ec2.DescribeInstancesOutput
-		err     error
-	)
-	if ids[0] == "" {
-		outputs, err = client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{Filters: []ec2types.Filter{filterName, filterTag, filterStatus}})
-	} else {
-		outputs, err = client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{Filters: []ec2types.Filter{filterName, filterTag, filterStatus}, InstanceIds: ids})
-	}
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	instacneIds := make([]string, 0)
-	for _, reservation := range outputs.Reservations {
-		for _, instance := range reservation.Instances {
-			fmt.Printf("%s (%s): %v\n", *instance.InstanceId, instance.InstanceType, instance.State.Name)
-			instacneIds = append(instacneIds, *instance.InstanceId)
-		}
-	}
-	return instacneIds
-}
-
-func CreatePerInstance(cfg aws.Config, instanceID, account *string) {
-
-	client := cloudwatch.NewFromConfig(cfg)
-
-	alarmName := fmt.Sprintf("awsec2-%s-%s", *instanceID, alarmNamePrefix)
-	putInput := &cloudwatch.PutMetricAlarmInput{
-		AlarmName:          &alarmName,
-		ComparisonOperator: types.ComparisonOperatorLessThanThreshold,
-		EvaluationPeriods:  aws.Int32(1),
-		MetricName:         aws.String("CPUUtilization"),
-		Namespace:          aws.String("AWS/EC2"),
-		Period:             aws.Int32(900),
-		Statistic:          types.StatisticAverage,
-		Threshold:          aws.Float64(threshold),
-		ActionsEnabled:     aws.Bool(true),
-		AlarmDescription:   aws.String(fmt.Sprintf("Alarm when server CPU falls below %f percent", threshold)),
-		AlarmActions: []string{
-			fmt.Sprintf("arn:aws:swf:"+cfg.Region+":%s:action/actions/AWS_EC2.InstanceId.%s/1.0", *account, action),
-			fmt.Sprintf("arn:aws:sns:"+cfg.Region+":%s:%s", *account, snsTopic),
-		},
-		Dimensions: []types.Dimension{
-			{
-				Name:  aws.String("InstanceId"),
-				Value: instanceID,
-			},
-		},
-	}
-
-	_, err := CreateMetricAlarm(context.TODO(), client, putInput)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	enableInput := &cloudwatch.EnableAlarmActionsInput{
-		AlarmNames: []string{
-			alarmName,
-		},
-	}
-
-	_, err = EnableAlarm(context.TODO(), client, enableInput)
-	if err != nil {
-		fmt.Println(err)
-		return
+func (t tagFilters) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid -tag %q, expected key=value", value)
 	}
-
-	fmt.Println("Enabled alarm " + alarmName + " for EC2 instance " + *instanceID)
+	t[key] = val
+	return nil
 }
 
 func init() {
@@ -176,6 +74,23 @@ func init() {
 	flag.StringVar(&action, "action", "Terminate", "EC2 action to take when alarm is triggered: Terminate, Stop, or Reboot (default: Terminate))")
 	flag.Float64Var(&threshold, "thres", 1.0, "CPU Utilization threshold to trigger alarm (default: 1.0)")
 	flag.IntVar(&period, "p", 900, "Period in seconds (default: 900)")
+	flag.StringVar(&mode, "mode", "create", "Mode of operation: create or reconcile (default: create)")
+	flag.StringVar(&namespace, "namespace", "AWS/EC2", "CloudWatch namespace (default: AWS/EC2)")
+	flag.StringVar(&metricName, "metric", "CPUUtilization", "CloudWatch metric name (default: CPUUtilization)")
+	flag.StringVar(&statistic, "statistic", "Average", "CloudWatch statistic: Average, Sum, SampleCount, Minimum, or Maximum (default: Average)")
+	flag.StringVar(&operator, "operator", "LessThanThreshold", "Comparison operator, e.g. LessThanThreshold or GreaterThanThreshold (default: LessThanThreshold)")
+	flag.IntVar(&evaluationPeriods, "evaluation-periods", 1, "Number of periods to evaluate (default: 1)")
+	flag.IntVar(&datapointsToAlarm, "datapoints-to-alarm", 0, "Number of datapoints within evaluation-periods that must breach (default: evaluation-periods)")
+	flag.StringVar(&unit, "unit", "", "CloudWatch unit, e.g. Percent (optional)")
+	flag.StringVar(&configPath, "config", "", "Path to a YAML/JSON config file declaring metric and composite alarms per instance, instead of the flags above (runs only against the caller's own account/region; incompatible with -regions and -role-arns)")
+	flag.StringVar(&regionsFlag, "regions", "", "Comma-separated regions to cover, or \"all\" for every enabled region (default: the caller's configured region)")
+	flag.StringVar(&roleArnsFlag, "role-arns", "", "Comma-separated IAM role ARNs to AssumeRole into, one per account to cover (default: the caller's own account)")
+	flag.IntVar(&concurrency, "concurrency", 4, "Maximum number of (account, region) targets to process at once (default: 4)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the intended diff instead of calling PutMetricAlarm")
+	flag.Var(tagFlag, "tag", "Additional tag filter key=value, beyond -t's tag-key check (repeatable)")
+	flag.StringVar(&vpcID, "vpc", "", "Filter instances by VPC ID")
+	flag.StringVar(&subnetID, "subnet", "", "Filter instances by subnet ID")
+	flag.StringVar(&instanceType, "instance-type", "", "Filter instances by EC2 instance type")
 }
 
 func errhandler(dryrun bool) {
@@ -183,6 +98,12 @@ func errhandler(dryrun bool) {
 		log.Println("Dry run, Skip error handling")
 		return
 	}
+	if configPath != "" {
+		if regionsFlag != "" || roleArnsFlag != "" {
+			log.Fatalln("-config does not support -regions or -role-arns; it always runs against the caller's own account and configured region")
+		}
+		return
+	}
 	if instanceName == "" && tagKey == "" && instanceIDs == "" {
 		log.Fatalln("You must provide an instance name, a tag key, or instance IDs")
 	}
@@ -198,30 +119,216 @@ func errhandler(dryrun bool) {
 	if period != 1 && period != 5 && period != 10 && period != 30 && period % 60 != 0 {
 		log.Fatalln("Valid periods are 1, 5, 10, 30, or multiples of 60")
 	}
+	if mode != "create" && mode != "reconcile" {
+		log.Fatalln("Valid modes are create or reconcile")
+	}
+	if _, err := alarm.ValidateStatistic(statistic); err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := alarm.ValidateOperator(operator); err != nil {
+		log.Fatalln(err)
+	}
+	if concurrency < 1 {
+		log.Fatalln("-concurrency must be at least 1")
+	}
+}
+
+// resolveInstanceIDs returns the instance IDs named by -i. A value starting with "@" is treated
+// as a path to a file containing IDs, one per line or comma-separated, instead of a literal list.
+func resolveInstanceIDs(raw string) []string {
+	if !strings.HasPrefix(raw, "@") {
+		return strings.Split(raw, ",")
+	}
+
+	data, err := os.ReadFile(raw[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		ids = append(ids, splitNonEmpty(line)...)
+	}
+	if len(ids) == 0 {
+		return []string{""}
+	}
+	return ids
+}
+
+// splitNonEmpty splits a comma-separated flag value, dropping blank entries.
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// containsAll reports whether regions contains the literal "all" entry, wherever it appears in
+// the comma list, so e.g. "all,us-west-2" is caught instead of being treated as the literal
+// region name "all".
+func containsAll(regions []string) bool {
+	for _, r := range regions {
+		if r == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// fanOutTarget is one (account-assuming role, region) pair to process.
+type fanOutTarget struct {
+	roleArn string
+	region  string
+}
+
+// targetSummary reports the outcome of processing one fanOutTarget.
+type targetSummary struct {
+	account string
+	region  string
+	count   int
+	err     error
+}
+
+// runFanOut loads credentials for every (roleArn, region) pair in targets, resolves the caller's
+// account in that target, and runs fn against a bounded pool of goroutines, collecting one
+// targetSummary per target.
+func runFanOut(ctx context.Context, baseCfg aws.Config, targets []fanOutTarget, concurrency int, fn func(ctx context.Context, cfg aws.Config, account string) (int, error)) []targetSummary {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	results := make([]targetSummary, len(targets))
+
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t fanOutTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			targetCfg := alarm.ConfigForTarget(baseCfg, t.roleArn, t.region)
+			stssvc := sts.NewFromConfig(targetCfg)
+			identity, err := stssvc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				results[i] = targetSummary{region: t.region, err: fmt.Errorf("sts error: %w", err)}
+				return
+			}
+
+			count, err := fn(ctx, targetCfg, *identity.Account)
+			results[i] = targetSummary{account: *identity.Account, region: t.region, count: count, err: err}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
 }
 
 func main() {
 	flag.Parse()
 	errhandler(false)
 
-	ids_slice := strings.Split(instanceIDs, ",")
-
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		panic("configuration error, " + err.Error())
 	}
 
-	ids := GetInstanceIds(cfg, instanceName, tagKey, ids_slice, running)
+	if configPath != "" {
+		stssvc := sts.NewFromConfig(cfg)
+		output, err := stssvc.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+		if err != nil {
+			log.Fatalln("sts error: " + err.Error())
+		}
 
-	stssvc := sts.NewFromConfig(cfg)
-	input := &sts.GetCallerIdentityInput{}
-	output, err := stssvc.GetCallerIdentity(context.TODO(), input)
-	if err != nil {
-		log.Fatalln("sts error: " + err.Error())
+		configs, err := alarm.LoadConfigFile(configPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, instCfg := range configs {
+			if err := alarm.ApplyInstanceConfig(context.TODO(), cfg, *output.Account, instCfg, dryRun); err != nil {
+				log.Println(err)
+			}
+		}
+		return
+	}
+
+	roleArns := splitNonEmpty(roleArnsFlag)
+	if len(roleArns) == 0 {
+		roleArns = []string{""}
+	}
+
+	regions := splitNonEmpty(regionsFlag)
+	if containsAll(regions) {
+		if len(regions) > 1 {
+			log.Fatalln(`-regions "all" cannot be combined with other regions`)
+		}
+		regions, err = alarm.ListAllRegions(context.TODO(), cfg)
+		if err != nil {
+			log.Fatalln("list regions error: " + err.Error())
+		}
+	} else if len(regions) == 0 {
+		regions = []string{cfg.Region}
+	}
+
+	targets := make([]fanOutTarget, 0, len(roleArns)*len(regions))
+	for _, roleArn := range roleArns {
+		for _, region := range regions {
+			targets = append(targets, fanOutTarget{roleArn: roleArn, region: region})
+		}
+	}
+
+	ids_slice := resolveInstanceIDs(instanceIDs)
+	instanceFilter := alarm.InstanceFilter{
+		Tags:         tagFlag,
+		VPCID:        vpcID,
+		SubnetID:     subnetID,
+		InstanceType: instanceType,
 	}
 
-	for _, id := range ids {
-		CreatePerInstance(cfg, &id, output.Account)
+	opts := alarm.Options{
+		AlarmNamePrefix:   alarmNamePrefix,
+		SNSTopic:          snsTopic,
+		Action:            action,
+		Threshold:         threshold,
+		Period:            int32(period),
+		Namespace:         namespace,
+		MetricName:        metricName,
+		Statistic:         statistic,
+		Operator:          operator,
+		EvaluationPeriods: int32(evaluationPeriods),
+		DatapointsToAlarm: int32(datapointsToAlarm),
+		Unit:              unit,
+		DryRun:            dryRun,
 	}
 
+	results := runFanOut(context.TODO(), cfg, targets, concurrency, func(ctx context.Context, targetCfg aws.Config, account string) (int, error) {
+		ids, err := alarm.GetInstanceIds(targetCfg, instanceName, tagKey, ids_slice, running, instanceFilter)
+		if err != nil {
+			return 0, err
+		}
+
+		if mode == "reconcile" {
+			if err := alarm.ReconcileAlarms(ctx, targetCfg, ids, account, opts); err != nil {
+				return len(ids), err
+			}
+			return len(ids), nil
+		}
+
+		for _, id := range ids {
+			if err := alarm.CreateAlarmForInstance(ctx, targetCfg, id, account, opts); err != nil {
+				log.Println(err)
+			}
+		}
+		return len(ids), nil
+	})
+
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Printf("region %s: error: %v\n", r.region, r.err)
+			continue
+		}
+		fmt.Printf("account %s, region %s: %d instance(s) processed\n", r.account, r.region, r.count)
+	}
 }